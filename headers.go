@@ -0,0 +1,153 @@
+package scgi
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// WriteSCGIHeaders writes vars as an SCGI header block: a netstring
+// containing the null-delimited key/value pairs described by the SCGI
+// protocol. Per the protocol, CONTENT_LENGTH, if present in vars, is always
+// written first, followed by SCGI if present; neither is added when missing.
+// The remaining variables follow in unspecified
+// order, which the protocol says is not significant.
+func WriteSCGIHeaders(w io.Writer, vars map[string]string) error {
+	buf := &bytes.Buffer{}
+
+	writeVar := func(key, val string) {
+		buf.WriteString(key)
+		buf.WriteByte(0x00)
+		buf.WriteString(val)
+		buf.WriteByte(0x00)
+	}
+
+	if val, ok := vars["CONTENT_LENGTH"]; ok {
+		writeVar("CONTENT_LENGTH", val)
+	}
+	if val, ok := vars["SCGI"]; ok {
+		writeVar("SCGI", val)
+	}
+	for key, val := range vars {
+		if key == "CONTENT_LENGTH" || key == "SCGI" {
+			continue
+		}
+		writeVar(key, val)
+	}
+
+	return WriteNetstring(w, buf.Bytes())
+}
+
+// ReadSCGIHeaders reads a netstring-encoded SCGI header block from r and
+// decodes it into its key/value pairs.
+func ReadSCGIHeaders(r *bufio.Reader) (map[string]string, error) {
+	block, err := ReadNetstring(r)
+	if err != nil {
+		return nil, err
+	}
+	return varsFromHeaderBlock(block)
+}
+
+// varsFromHeaderBlock decodes a null-delimited SCGI header block, as read
+// from a netstring, into its key/value pairs.
+func varsFromHeaderBlock(block string) (map[string]string, error) {
+	parts := strings.Split(block, "\x00")
+	if len(parts) > 0 && parts[len(parts)-1] == "" {
+		parts = parts[:len(parts)-1]
+	}
+	if len(parts)%2 != 0 {
+		return nil, errors.New("scgi: header block has an odd number of fields")
+	}
+
+	vars := make(map[string]string, len(parts)/2)
+	for i := 0; i < len(parts); i += 2 {
+		vars[parts[i]] = parts[i+1]
+	}
+	return vars, nil
+}
+
+// RequestFromMap creates an *http.Request from the SCGI/CGI meta-variables in
+// params, in the same way net/http/cgi.RequestFromMap builds a request out of
+// a CGI child's environment. The returned request's Body is always nil; it is
+// the caller's responsibility to attach one.
+func RequestFromMap(params map[string]string) (*http.Request, error) {
+	method := params["REQUEST_METHOD"]
+	if method == "" {
+		method = "GET"
+	}
+
+	rawURL := params["REQUEST_URI"]
+	if rawURL == "" {
+		rawURL = params["SCRIPT_NAME"] + params["PATH_INFO"]
+		if q := params["QUERY_STRING"]; q != "" {
+			rawURL += "?" + q
+		}
+	}
+	if rawURL == "" {
+		rawURL = "/"
+	}
+	u, err := url.ParseRequestURI(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("scgi: invalid request uri %q: %v", rawURL, err)
+	}
+
+	proto := params["SERVER_PROTOCOL"]
+	if proto == "" {
+		proto = "HTTP/1.1"
+	}
+	protoMajor, protoMinor, ok := http.ParseHTTPVersion(proto)
+	if !ok {
+		protoMajor, protoMinor = 1, 1
+	}
+
+	header := make(http.Header)
+	for k, v := range params {
+		if !strings.HasPrefix(k, "HTTP_") || k == "HTTP_HOST" {
+			continue
+		}
+		name := strings.Replace(strings.TrimPrefix(k, "HTTP_"), "_", "-", -1)
+		header.Add(http.CanonicalHeaderKey(name), v)
+	}
+	if ct := params["CONTENT_TYPE"]; ct != "" {
+		header.Set("Content-Type", ct)
+	}
+
+	req := &http.Request{
+		Method:        method,
+		URL:           u,
+		RequestURI:    rawURL,
+		Proto:         proto,
+		ProtoMajor:    protoMajor,
+		ProtoMinor:    protoMinor,
+		Header:        header,
+		ContentLength: contentLength(params),
+		Host:          params["HTTP_HOST"],
+		RemoteAddr:    remoteAddr(params),
+	}
+
+	if params["HTTPS"] == "on" || params["HTTPS"] == "1" {
+		req.TLS = &tls.ConnectionState{}
+	}
+
+	return req, nil
+}
+
+// remoteAddr joins REMOTE_ADDR and REMOTE_PORT into the host:port form
+// expected of http.Request.RemoteAddr.
+func remoteAddr(params map[string]string) string {
+	host := params["REMOTE_ADDR"]
+	if host == "" {
+		return ""
+	}
+	if port := params["REMOTE_PORT"]; port != "" {
+		return net.JoinHostPort(host, port)
+	}
+	return host
+}
@@ -0,0 +1,98 @@
+package scgi
+
+import (
+	"bufio"
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestWriteSCGIHeadersOrder(t *testing.T) {
+	buf := &bytes.Buffer{}
+	vars := map[string]string{
+		"SCGI":            "1",
+		"REQUEST_METHOD":  "GET",
+		"CONTENT_LENGTH":  "0",
+		"SERVER_PROTOCOL": "HTTP/1.1",
+	}
+	if err := WriteSCGIHeaders(buf, vars); err != nil {
+		t.Fatalf("WriteSCGIHeaders: %v", err)
+	}
+
+	block, err := ReadNetstring(bufio.NewReader(buf))
+	if err != nil {
+		t.Fatalf("ReadNetstring: %v", err)
+	}
+
+	// The SCGI protocol requires CONTENT_LENGTH first and SCGI second,
+	// regardless of map iteration order.
+	if !strings.HasPrefix(block, "CONTENT_LENGTH\x000\x00SCGI\x001\x00") {
+		t.Fatalf("expected CONTENT_LENGTH then SCGI first, got %q", block)
+	}
+}
+
+func TestWriteReadSCGIHeadersRoundTrip(t *testing.T) {
+	want := map[string]string{
+		"CONTENT_LENGTH":  "12",
+		"SCGI":            "1",
+		"REQUEST_METHOD":  "POST",
+		"SERVER_PROTOCOL": "HTTP/1.1",
+		"HTTP_HOST":       "example.com",
+	}
+
+	buf := &bytes.Buffer{}
+	if err := WriteSCGIHeaders(buf, want); err != nil {
+		t.Fatalf("WriteSCGIHeaders: %v", err)
+	}
+
+	got, err := ReadSCGIHeaders(bufio.NewReader(buf))
+	if err != nil {
+		t.Fatalf("ReadSCGIHeaders: %v", err)
+	}
+
+	if !reflect.DeepEqual(want, got) {
+		t.Fatalf("ReadSCGIHeaders = %#v, want %#v", got, want)
+	}
+}
+
+func TestRequestFromMap(t *testing.T) {
+	params := map[string]string{
+		"REQUEST_METHOD":  "POST",
+		"REQUEST_URI":     "/foo/bar?a=1",
+		"SERVER_PROTOCOL": "HTTP/1.1",
+		"HTTP_HOST":       "example.com",
+		"HTTP_X_FOO":      "bar",
+		"CONTENT_LENGTH":  "12",
+		"CONTENT_TYPE":    "application/json",
+		"REMOTE_ADDR":     "10.0.0.1",
+		"REMOTE_PORT":     "4321",
+	}
+
+	req, err := RequestFromMap(params)
+	if err != nil {
+		t.Fatalf("RequestFromMap: %v", err)
+	}
+
+	if req.Method != "POST" {
+		t.Errorf("Method = %q, want POST", req.Method)
+	}
+	if req.URL.Path != "/foo/bar" || req.URL.RawQuery != "a=1" {
+		t.Errorf("URL = %q, want path /foo/bar and query a=1", req.URL)
+	}
+	if req.Host != "example.com" {
+		t.Errorf("Host = %q, want example.com", req.Host)
+	}
+	if got := req.Header.Get("X-Foo"); got != "bar" {
+		t.Errorf("Header X-Foo = %q, want bar", got)
+	}
+	if got := req.Header.Get("Content-Type"); got != "application/json" {
+		t.Errorf("Header Content-Type = %q, want application/json", got)
+	}
+	if req.ContentLength != 12 {
+		t.Errorf("ContentLength = %d, want 12", req.ContentLength)
+	}
+	if req.RemoteAddr != "10.0.0.1:4321" {
+		t.Errorf("RemoteAddr = %q, want 10.0.0.1:4321", req.RemoteAddr)
+	}
+}
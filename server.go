@@ -0,0 +1,125 @@
+package scgi
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strconv"
+)
+
+// Serve accepts incoming connections on the listener l, reading an SCGI
+// request from each one and dispatching it to handler. Serve always blocks
+// until l.Accept returns an error, which it then returns.
+//
+// This makes the scgi package usable as an SCGI application behind a
+// front-end web server such as nginx, lighttpd, or Apache's mod_scgi, in the
+// same way net/http/fcgi.Serve is used for FastCGI applications.
+func Serve(l net.Listener, handler http.Handler) error {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return err
+		}
+		go ServeConn(conn, handler)
+	}
+}
+
+// ServeConn reads a single SCGI request from conn, dispatches it to handler,
+// and closes conn once the response has been written. If handler is nil,
+// http.DefaultServeMux is used.
+func ServeConn(conn net.Conn, handler http.Handler) {
+	defer conn.Close()
+	if handler == nil {
+		handler = http.DefaultServeMux
+	}
+	(&Handler{Handler: handler}).serve(conn)
+}
+
+// Handler adapts an SCGI connection to an http.Handler. Serve and ServeConn
+// are convenience wrappers around Handler for the common cases of accepting
+// from a net.Listener or handling an already-accepted net.Conn.
+type Handler struct {
+	// Handler is invoked for each request decoded from an SCGI connection.
+	Handler http.Handler
+}
+
+// serve reads one SCGI request from conn and dispatches it to h.Handler. If
+// the request can't be decoded, a "Status: 400 Bad Request" response is
+// written instead, since nothing has been written to conn yet at that point.
+func (h *Handler) serve(conn net.Conn) {
+	br := bufio.NewReader(conn)
+	w := &response{conn: conn, header: make(http.Header)}
+
+	vars, err := ReadSCGIHeaders(br)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	req, err := RequestFromMap(vars)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	req.Body = ioutil.NopCloser(io.LimitReader(br, contentLength(vars)))
+
+	handler := h.Handler
+	if handler == nil {
+		handler = http.DefaultServeMux
+	}
+	handler.ServeHTTP(w, req)
+	w.finish()
+}
+
+// contentLength returns the CONTENT_LENGTH variable as an int64, or 0 if it
+// is missing or malformed.
+func contentLength(vars map[string]string) int64 {
+	n, err := strconv.ParseInt(vars["CONTENT_LENGTH"], 10, 64)
+	if err != nil || n < 0 {
+		return 0
+	}
+	return n
+}
+
+// response implements http.ResponseWriter for a single SCGI request. It
+// writes a CGI-style "Status:" response directly to the underlying
+// connection, the same wire format net/http/cgi expects a child process to
+// produce on stdout.
+type response struct {
+	conn        net.Conn
+	header      http.Header
+	wroteHeader bool
+	status      int
+}
+
+func (w *response) Header() http.Header { return w.header }
+
+func (w *response) WriteHeader(status int) {
+	if w.wroteHeader {
+		return
+	}
+	w.wroteHeader = true
+	w.status = status
+
+	fmt.Fprintf(w.conn, "Status: %d %s\r\n", status, http.StatusText(status))
+	w.header.Write(w.conn)
+	io.WriteString(w.conn, "\r\n")
+}
+
+func (w *response) Write(p []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	return w.conn.Write(p)
+}
+
+// finish ensures a response header has been written even if the handler
+// never called Write or WriteHeader.
+func (w *response) finish() {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+}
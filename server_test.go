@@ -0,0 +1,94 @@
+package scgi
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func scgiRequestBytes(vars map[string]string, body string) []byte {
+	buf := &bytes.Buffer{}
+	WriteSCGIHeaders(buf, vars)
+	buf.WriteString(body)
+	return buf.Bytes()
+}
+
+func TestHandlerServeDecodesRequest(t *testing.T) {
+	client, server := net.Pipe()
+
+	var gotMethod, gotPath, gotHost string
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotMethod = r.Method
+		gotPath = r.URL.Path
+		gotHost = r.Host
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte("hello"))
+	})
+
+	done := make(chan struct{})
+	go func() {
+		(&Handler{Handler: handler}).serve(server)
+		server.Close()
+		close(done)
+	}()
+
+	vars := map[string]string{
+		"CONTENT_LENGTH":  "0",
+		"SCGI":            "1",
+		"REQUEST_METHOD":  "GET",
+		"SERVER_PROTOCOL": "HTTP/1.1",
+		"REQUEST_URI":     "/foo",
+		"HTTP_HOST":       "example.com",
+	}
+	if _, err := client.Write(scgiRequestBytes(vars, "")); err != nil {
+		t.Fatalf("write request: %v", err)
+	}
+
+	resp, err := ioutil.ReadAll(client)
+	if err != nil {
+		t.Fatalf("read response: %v", err)
+	}
+	<-done
+
+	if gotMethod != "GET" || gotPath != "/foo" || gotHost != "example.com" {
+		t.Fatalf("got method=%q path=%q host=%q", gotMethod, gotPath, gotHost)
+	}
+	if !strings.HasPrefix(string(resp), "Status: 200 OK\r\n") {
+		t.Fatalf("response = %q, want Status: 200 OK prefix", resp)
+	}
+	if !strings.HasSuffix(string(resp), "hello") {
+		t.Fatalf("response = %q, want body hello", resp)
+	}
+}
+
+func TestHandlerServeMalformedRequestIsBadRequest(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+
+	done := make(chan struct{})
+	go func() {
+		(&Handler{Handler: http.NotFoundHandler()}).serve(server)
+		close(done)
+	}()
+
+	// A well-formed netstring whose contents are an odd number of
+	// null-delimited fields, which ReadSCGIHeaders rejects.
+	if _, err := client.Write([]byte("6:a\x00b\x00c\x00,")); err != nil {
+		t.Fatalf("write request: %v", err)
+	}
+
+	want := "Status: 400 Bad Request\r\n\r\n"
+	got := make([]byte, len(want))
+	if _, err := io.ReadFull(client, got); err != nil {
+		t.Fatalf("read response: %v", err)
+	}
+	if string(got) != want {
+		t.Fatalf("response = %q, want %q", got, want)
+	}
+
+	<-done
+}
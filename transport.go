@@ -0,0 +1,477 @@
+package scgi
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/textproto"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultTransport is the default Transport used by a Client with no
+// Transport of its own.
+var DefaultTransport = &Transport{}
+
+// Transport implements net/http.RoundTripper for SCGI, analogous to
+// net/http.Transport. It dials unix domain sockets or host:port addresses,
+// reuses idle connections to the same address where possible, and honors
+// req.Context() for cancellation across dialing, writing, and reading.
+type Transport struct {
+	// Dial, if non-nil, is used to dial new SCGI connections. DialContext is
+	// preferred when both are set, since it can be canceled via the
+	// request's context.
+	Dial func(network, addr string) (net.Conn, error)
+
+	// DialContext, if non-nil, is used to dial new SCGI connections.
+	DialContext func(ctx context.Context, network, addr string) (net.Conn, error)
+
+	// MaxIdleConns bounds the total number of idle connections kept across
+	// all addresses. Zero means no limit.
+	MaxIdleConns int
+
+	// MaxConnsPerHost bounds the number of connections (idle or in use)
+	// tracked for a single address. Once that many are already tracked,
+	// further connections to that address are still dialed to serve the
+	// request, but are closed after a single use instead of being pooled.
+	// Zero means no limit.
+	MaxConnsPerHost int
+
+	// IdleConnTimeout is the maximum amount of time an idle connection is
+	// kept before being closed instead of reused. Zero means no limit.
+	IdleConnTimeout time.Duration
+
+	// ResponseHeaderTimeout, if non-zero, specifies the amount of time to
+	// wait for a response's headers after fully writing the request.
+	ResponseHeaderTimeout time.Duration
+
+	// Env holds extra static CGI meta-variables injected into every
+	// request, on top of the ones derived from it.
+	Env map[string]string
+
+	mu      sync.Mutex
+	idle    map[string][]*idleConn
+	numOpen map[string]int
+}
+
+// idleConn is a pooled connection waiting to be reused.
+type idleConn struct {
+	conn   net.Conn
+	idleAt time.Time
+}
+
+// RoundTrip implements the net/http.RoundTripper interface.
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if (req.URL.Host != "" && req.URL.Path != "") || (req.URL.Host == "" && req.URL.Path == "") {
+		return nil, errors.New("scgi: round trip: invalid scgi connection string")
+	}
+	if req.Body != nil {
+		defer req.Body.Close()
+	}
+
+	// The SCGI protocol requires CONTENT_LENGTH up front, so a body with an
+	// unknown length has to be buffered somewhere before it can be streamed
+	// out. A body with a known length is streamed straight from req.Body,
+	// which keeps large uploads (e.g. torrent file additions to rtorrent)
+	// from being held entirely in memory.
+	var body io.Reader
+	var bodyLen int64
+	var err error
+	if req.Body != nil {
+		if req.ContentLength >= 0 {
+			body = req.Body
+			bodyLen = req.ContentLength
+		} else {
+			var tmp *os.File
+			tmp, bodyLen, err = bufferBody(req.Body)
+			if err != nil {
+				return nil, fmt.Errorf("scgi: round trip: %v", err)
+			}
+			defer tmp.Close()
+			body = tmp
+		}
+	}
+
+	network, addr := scgiDialTarget(req.URL)
+	key := network + ":" + addr
+
+	ctx := req.Context()
+	scgiConn, pooled, err := t.getConn(ctx, key, network, addr)
+	if err != nil {
+		return nil, fmt.Errorf("scgi: round trip over %s: %v", network, err)
+	}
+
+	// Abort the connection if the request's context is canceled at any point
+	// up to and including the caller draining resp.Body; this unblocks
+	// whichever of the write, header-read or body-read phases is in flight.
+	// The dial has already finished by this point; it is only cancelable
+	// through the ctx passed to getConn. Ownership of stopping the watcher passes to resp.Body once one
+	// is returned; until then, watcherDone covers every early-return path.
+	watcherDone := make(chan struct{})
+	stopWatcher := sync.Once{}
+	closeWatcherDone := func() { stopWatcher.Do(func() { close(watcherDone) }) }
+	transferred := false
+	defer func() {
+		if !transferred {
+			closeWatcherDone()
+		}
+	}()
+	go func() {
+		select {
+		case <-ctx.Done():
+			scgiConn.Close()
+		case <-watcherDone:
+		}
+	}()
+
+	// Build the required SCGI headers, the usual CGI meta-variables that
+	// real-world SCGI applications rely on, plus one SCGI variable per HTTP
+	// header and any static vars configured on the Transport.
+	vars := map[string]string{
+		"CONTENT_LENGTH":  strconv.FormatInt(bodyLen, 10),
+		"SCGI":            "1",
+		"REQUEST_METHOD":  req.Method,
+		"SERVER_PROTOCOL": req.Proto,
+		"REQUEST_URI":     req.URL.RequestURI(),
+		"SCRIPT_NAME":     "",
+		"PATH_INFO":       req.URL.Path,
+		"QUERY_STRING":    req.URL.RawQuery,
+	}
+	if host := req.Host; host != "" {
+		vars["SERVER_NAME"] = host
+		vars["HTTP_HOST"] = host
+	} else {
+		vars["SERVER_NAME"] = req.URL.Hostname()
+	}
+	if network == "tcp" {
+		vars["SERVER_PORT"] = req.URL.Port()
+	} else {
+		vars["SERVER_PORT"] = "0"
+	}
+	vars["REMOTE_ADDR"] = remoteAddrFromRequest(req, scgiConn.LocalAddr())
+
+	if req.URL.Scheme == "https" || req.TLS != nil {
+		vars["HTTPS"] = "on"
+		if state := req.TLS; state != nil {
+			vars["SSL_PROTOCOL"] = tlsVersionName(state.Version)
+			vars["SSL_CIPHER"] = tls.CipherSuiteName(state.CipherSuite)
+			if len(state.PeerCertificates) > 0 {
+				vars["SSL_CLIENT_S_DN"] = state.PeerCertificates[0].Subject.String()
+			}
+		}
+	}
+
+	for hk, hv := range req.Header {
+		name := "HTTP_" + strings.ToUpper(strings.Replace(hk, "-", "_", -1))
+		vars[name] = strings.Join(hv, ",")
+	}
+	for ek, ev := range t.Env {
+		vars[ek] = ev
+	}
+
+	if err := WriteSCGIHeaders(scgiConn, vars); err != nil {
+		t.discard(key, scgiConn, pooled)
+		return nil, fmt.Errorf("scgi: round trip: %v", err)
+	}
+	if body != nil {
+		if _, err := io.Copy(scgiConn, body); err != nil {
+			t.discard(key, scgiConn, pooled)
+			return nil, fmt.Errorf("scgi: round trip write error: %v", ctxErr(ctx, err))
+		}
+	}
+
+	if t.ResponseHeaderTimeout > 0 {
+		scgiConn.SetReadDeadline(time.Now().Add(t.ResponseHeaderTimeout))
+	}
+
+	// There isn't a method for cgi response parsing, but net/http/cgi's child
+	// output is close enough to reuse: read all of the response's header
+	// lines as a MIME header block, the same as a CGI child's stdout, rather
+	// than requiring Status to be the very first line.
+	scgiRead := bufio.NewReader(scgiConn)
+
+	tp := textproto.NewReader(scgiRead)
+	mimeHeader, err := tp.ReadMIMEHeader()
+	if err != nil && (err != io.EOF || len(mimeHeader) == 0) {
+		t.discard(key, scgiConn, pooled)
+		return nil, fmt.Errorf("scgi: round trip: invalid format: %v", ctxErr(ctx, err))
+	}
+	scgiConn.SetReadDeadline(time.Time{})
+
+	header := http.Header(mimeHeader)
+
+	// Synthesize the status line from an optional Status header, promoting
+	// a Location with no Status into a redirect, as net/http/cgi does for a
+	// CGI child's output.
+	status := "200 OK"
+	if v := header.Get("Status"); v != "" {
+		status = v
+		header.Del("Status")
+	} else if header.Get("Location") != "" {
+		status = "302 Found"
+	}
+	if header.Get("Content-Type") == "" {
+		header.Set("Content-Type", "text/html; charset=utf-8")
+	}
+
+	statusLine := &bytes.Buffer{}
+	fmt.Fprintf(statusLine, "%s %s\r\n", req.Proto, status)
+	header.Write(statusLine)
+	statusLine.WriteString("\r\n")
+
+	scgiRead = bufio.NewReader(io.MultiReader(statusLine, scgiRead))
+
+	resp, err := http.ReadResponse(scgiRead, req)
+	if err != nil {
+		t.discard(key, scgiConn, pooled)
+		return nil, errors.New("scgi: round trip")
+	}
+
+	// Keep resp.Body tied to the live connection, returning it to the idle
+	// pool once fully drained instead of leaving it to a bufio.Reader that
+	// may otherwise hold the connection open indefinitely. From here on the
+	// context watcher's lifetime is tied to the body instead of to this
+	// function returning, so a cancellation mid-body-read still aborts it.
+	transferred = true
+	resp.Body = &bodyEOFSignal{
+		body: resp.Body,
+		onEOF: func() {
+			closeWatcherDone()
+			t.putIdle(key, scgiConn, pooled)
+		},
+		onEarlyClose: func() {
+			closeWatcherDone()
+			t.discard(key, scgiConn, pooled)
+		},
+	}
+
+	return resp, nil
+}
+
+// getConn returns a connection for key, reusing an idle one when available
+// and not stale, or dialing a new one otherwise. pooled reports whether the
+// connection counts against MaxConnsPerHost/MaxIdleConns and should be
+// returned to the pool via putIdle rather than always closed.
+func (t *Transport) getConn(ctx context.Context, key, network, addr string) (conn net.Conn, pooled bool, err error) {
+	t.mu.Lock()
+	for len(t.idle[key]) > 0 {
+		n := len(t.idle[key])
+		ic := t.idle[key][n-1]
+		t.idle[key] = t.idle[key][:n-1]
+
+		if t.IdleConnTimeout > 0 && time.Since(ic.idleAt) > t.IdleConnTimeout {
+			t.mu.Unlock()
+			ic.conn.Close()
+			t.decOpen(key)
+			t.mu.Lock()
+			continue
+		}
+
+		t.mu.Unlock()
+		return ic.conn, true, nil
+	}
+
+	pooled = t.MaxConnsPerHost <= 0 || t.numOpen[key] < t.MaxConnsPerHost
+	if pooled {
+		if t.numOpen == nil {
+			t.numOpen = make(map[string]int)
+		}
+		t.numOpen[key]++
+	}
+	t.mu.Unlock()
+
+	conn, err = t.dial(ctx, network, addr)
+	if err != nil {
+		if pooled {
+			t.decOpen(key)
+		}
+		return nil, false, err
+	}
+	return conn, pooled, nil
+}
+
+func (t *Transport) dial(ctx context.Context, network, addr string) (net.Conn, error) {
+	if t.DialContext != nil {
+		return t.DialContext(ctx, network, addr)
+	}
+	if t.Dial != nil {
+		return t.Dial(network, addr)
+	}
+	d := &net.Dialer{}
+	return d.DialContext(ctx, network, addr)
+}
+
+// putIdle returns conn to the idle pool for key if it is still pooled and
+// doing so wouldn't exceed MaxIdleConns; otherwise it is closed.
+func (t *Transport) putIdle(key string, conn net.Conn, pooled bool) {
+	if !pooled {
+		conn.Close()
+		return
+	}
+
+	t.mu.Lock()
+	total := 0
+	for _, conns := range t.idle {
+		total += len(conns)
+	}
+	if t.MaxIdleConns > 0 && total >= t.MaxIdleConns {
+		t.mu.Unlock()
+		conn.Close()
+		t.decOpen(key)
+		return
+	}
+	if t.idle == nil {
+		t.idle = make(map[string][]*idleConn)
+	}
+	t.idle[key] = append(t.idle[key], &idleConn{conn: conn, idleAt: time.Now()})
+	t.mu.Unlock()
+}
+
+// discard closes conn and, if it was counted against MaxConnsPerHost,
+// removes it from that count.
+func (t *Transport) discard(key string, conn net.Conn, pooled bool) {
+	conn.Close()
+	if pooled {
+		t.decOpen(key)
+	}
+}
+
+func (t *Transport) decOpen(key string) {
+	t.mu.Lock()
+	if t.numOpen[key] > 0 {
+		t.numOpen[key]--
+	}
+	t.mu.Unlock()
+}
+
+// tlsVersionName returns the SSL_PROTOCOL value net/http/cgi-style backends
+// expect for a crypto/tls version number.
+func tlsVersionName(version uint16) string {
+	switch version {
+	case tls.VersionTLS10:
+		return "TLSv1"
+	case tls.VersionTLS11:
+		return "TLSv1.1"
+	case tls.VersionTLS12:
+		return "TLSv1.2"
+	case tls.VersionTLS13:
+		return "TLSv1.3"
+	default:
+		return "TLS"
+	}
+}
+
+// remoteAddrFromRequest derives the REMOTE_ADDR CGI variable. A caller that
+// already knows the address of the party it's proxying for (e.g. a reverse
+// proxy forwarding the downstream client's address) can set req.RemoteAddr
+// and have it take priority; otherwise the local end of the dialed
+// connection is used, which is REMOTE_ADDR from the backend's point of view
+// whether it was reached over tcp or a unix socket.
+func remoteAddrFromRequest(req *http.Request, local net.Addr) string {
+	if req.RemoteAddr != "" {
+		if host, _, err := net.SplitHostPort(req.RemoteAddr); err == nil {
+			return host
+		}
+		return req.RemoteAddr
+	}
+
+	switch a := local.(type) {
+	case *net.TCPAddr:
+		return a.IP.String()
+	case *net.UnixAddr:
+		return a.Name
+	default:
+		return local.String()
+	}
+}
+
+// scgiDialTarget returns the network and address RoundTrip should dial for
+// u, either a unix socket path or a tcp host:port.
+func scgiDialTarget(u *url.URL) (network, addr string) {
+	if u.Host == "" {
+		return "unix", u.Path
+	}
+	port := u.Port()
+	if port == "" {
+		port = "80"
+	}
+	return "tcp", u.Hostname() + ":" + port
+}
+
+// ctxErr reports ctx.Err() in place of err when the context has been
+// canceled, since that's almost always the more useful explanation for a
+// read or write failing mid-flight.
+func ctxErr(ctx context.Context, err error) error {
+	if cerr := ctx.Err(); cerr != nil {
+		return cerr
+	}
+	return err
+}
+
+// bufferBody copies r to a temporary file so its length is known up front,
+// which SCGI's CONTENT_LENGTH header requires. It's only needed when a
+// request body doesn't already report its length via ContentLength.
+func bufferBody(r io.Reader) (*os.File, int64, error) {
+	tmp, err := ioutil.TempFile("", "go-scgi-request")
+	if err != nil {
+		return nil, 0, fmt.Errorf("body buffer error: %v", err)
+	}
+	os.Remove(tmp.Name())
+
+	n, err := io.Copy(tmp, r)
+	if err != nil {
+		tmp.Close()
+		return nil, 0, fmt.Errorf("body buffer error: %v", err)
+	}
+	if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+		tmp.Close()
+		return nil, 0, fmt.Errorf("body buffer error: %v", err)
+	}
+
+	return tmp, n, nil
+}
+
+// bodyEOFSignal wraps an http.Response's body so the underlying SCGI
+// connection can be returned to the idle pool once the body has been fully
+// read, or discarded if it's closed early, rather than being left open by a
+// caller that never drains it. It mirrors the role of the identically named
+// type in net/http/transport.
+type bodyEOFSignal struct {
+	body         io.ReadCloser
+	onEOF        func()
+	onEarlyClose func()
+	done         bool
+}
+
+func (es *bodyEOFSignal) Read(p []byte) (int, error) {
+	n, err := es.body.Read(p)
+	if err == io.EOF && !es.done {
+		es.done = true
+		if es.onEOF != nil {
+			es.onEOF()
+		}
+	}
+	return n, err
+}
+
+func (es *bodyEOFSignal) Close() error {
+	err := es.body.Close()
+	if !es.done {
+		es.done = true
+		if es.onEarlyClose != nil {
+			es.onEarlyClose()
+		}
+	}
+	return err
+}
@@ -0,0 +1,214 @@
+package scgi
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// fakeSCGIServer answers every request on a unix socket with a canned
+// response that echoes back a couple of the SCGI vars it received, and
+// keeps each connection open across requests so reuse can be observed.
+func fakeSCGIServer(t *testing.T) (sockPath string, acceptCount *int32) {
+	t.Helper()
+
+	sockPath = filepath.Join(t.TempDir(), "test.sock")
+	l, err := net.Listen("unix", sockPath)
+	if err != nil {
+		t.Fatalf("Listen: %v", err)
+	}
+	t.Cleanup(func() { l.Close() })
+
+	acceptCount = new(int32)
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			atomic.AddInt32(acceptCount, 1)
+			go serveFakeConn(conn)
+		}
+	}()
+
+	return sockPath, acceptCount
+}
+
+func serveFakeConn(conn net.Conn) {
+	defer conn.Close()
+	br := bufio.NewReader(conn)
+	for {
+		vars, err := ReadSCGIHeaders(br)
+		if err != nil {
+			return
+		}
+		n, _ := strconv.ParseInt(vars["CONTENT_LENGTH"], 10, 64)
+		if _, err := io.CopyN(ioutil.Discard, br, n); err != nil {
+			return
+		}
+
+		fmt.Fprintf(conn, "Status: 200 OK\r\nContent-Length: 2\r\nX-Host: %s\r\nX-Remote-Addr: %s\r\n\r\nok",
+			vars["HTTP_HOST"], vars["REMOTE_ADDR"])
+	}
+}
+
+func scgiUnixRequest(t *testing.T, sockPath, host, remoteAddr string) *http.Request {
+	t.Helper()
+
+	req, err := http.NewRequest("GET", "http://placeholder/", nil)
+	if err != nil {
+		t.Fatalf("NewRequest: %v", err)
+	}
+	req.URL = &url.URL{Scheme: "scgi", Path: sockPath}
+	req.Host = host
+	req.RemoteAddr = remoteAddr
+	return req
+}
+
+func TestTransportRoundTripPropagatesHostAndRemoteAddr(t *testing.T) {
+	sockPath, _ := fakeSCGIServer(t)
+
+	tr := &Transport{}
+	req := scgiUnixRequest(t, sockPath, "example.com", "203.0.113.5:9999")
+
+	resp, err := tr.RoundTrip(req)
+	if err != nil {
+		t.Fatalf("RoundTrip: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if got := resp.Header.Get("X-Host"); got != "example.com" {
+		t.Errorf("X-Host = %q, want example.com", got)
+	}
+	if got := resp.Header.Get("X-Remote-Addr"); got != "203.0.113.5" {
+		t.Errorf("X-Remote-Addr = %q, want 203.0.113.5", got)
+	}
+
+	if _, err := ioutil.ReadAll(resp.Body); err != nil {
+		t.Fatalf("read body: %v", err)
+	}
+}
+
+// TestTransportRoundTripPropagatesCustomHeader exercises a real request
+// header through Transport.RoundTrip and this package's own Serve/Handler,
+// rather than a hand-rolled fake server, so a var-naming mismatch between the
+// two sides would actually be caught.
+func TestTransportRoundTripPropagatesCustomHeader(t *testing.T) {
+	sockPath := filepath.Join(t.TempDir(), "real.sock")
+	l, err := net.Listen("unix", sockPath)
+	if err != nil {
+		t.Fatalf("Listen: %v", err)
+	}
+	defer l.Close()
+
+	var got string
+	go Serve(l, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r.Header.Get("X-Custom")
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	req := scgiUnixRequest(t, sockPath, "example.com", "203.0.113.5:9999")
+	req.Header.Set("X-Custom", "hello")
+
+	resp, err := (&Transport{}).RoundTrip(req)
+	if err != nil {
+		t.Fatalf("RoundTrip: %v", err)
+	}
+	defer resp.Body.Close()
+	io.Copy(ioutil.Discard, resp.Body)
+
+	if got != "hello" {
+		t.Fatalf("server saw X-Custom = %q, want hello", got)
+	}
+}
+
+// TestTransportRoundTripContextCancelDuringBodyRead checks that canceling a
+// request's context unblocks a caller that's still draining resp.Body, not
+// just one still waiting on the dial/write/header-read phases.
+func TestTransportRoundTripContextCancelDuringBodyRead(t *testing.T) {
+	sockPath := filepath.Join(t.TempDir(), "slow.sock")
+	l, err := net.Listen("unix", sockPath)
+	if err != nil {
+		t.Fatalf("Listen: %v", err)
+	}
+	defer l.Close()
+
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		br := bufio.NewReader(conn)
+		if _, err := ReadSCGIHeaders(br); err != nil {
+			return
+		}
+
+		// Declare a long body, write a few bytes of it, then hang without
+		// ever sending the rest or closing the connection.
+		fmt.Fprint(conn, "Status: 200 OK\r\nContent-Length: 1000000\r\n\r\nabc")
+		select {}
+	}()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	req := scgiUnixRequest(t, sockPath, "example.com", "203.0.113.5:9999")
+	req = req.WithContext(ctx)
+
+	resp, err := (&Transport{}).RoundTrip(req)
+	if err != nil {
+		t.Fatalf("RoundTrip: %v", err)
+	}
+	defer resp.Body.Close()
+
+	time.AfterFunc(100*time.Millisecond, cancel)
+
+	readErr := make(chan error, 1)
+	go func() {
+		_, err := ioutil.ReadAll(resp.Body)
+		readErr <- err
+	}()
+
+	select {
+	case err := <-readErr:
+		if err == nil {
+			t.Fatal("expected a read error after the context was canceled, got nil")
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("resp.Body.Read did not unblock after the request's context was canceled")
+	}
+}
+
+func TestTransportReusesIdleConnection(t *testing.T) {
+	sockPath, acceptCount := fakeSCGIServer(t)
+
+	tr := &Transport{}
+
+	for i := 0; i < 2; i++ {
+		req := scgiUnixRequest(t, sockPath, "example.com", "203.0.113.5:9999")
+		resp, err := tr.RoundTrip(req)
+		if err != nil {
+			t.Fatalf("RoundTrip #%d: %v", i, err)
+		}
+		if _, err := ioutil.ReadAll(resp.Body); err != nil {
+			t.Fatalf("read body #%d: %v", i, err)
+		}
+		resp.Body.Close()
+	}
+
+	if got := atomic.LoadInt32(acceptCount); got != 1 {
+		t.Fatalf("accept count = %d, want 1 (connection should have been reused)", got)
+	}
+}